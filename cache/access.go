@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/lari4/bin-lookup-gateway/storage"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	// accessCountsKey is a hash of BIN prefix to lookups not yet flushed to Mongo.
+	accessCountsKey         = "bin-lookup-gateway:access-counts"
+	accessCountsFlushingKey = "bin-lookup-gateway:access-counts:flushing"
+	// accessFlushBatchKey is a hash with the ID ("id") and start time in
+	// Unix milliseconds ("at") of the batch in the flushing hash.
+	accessFlushBatchKey = "bin-lookup-gateway:access-counts:batch"
+	accessFlushLockKey  = "bin-lookup-gateway:access-counts:lock"
+)
+
+// releaseLock deletes the lock only while it still holds our token, so a
+// flush that outlived the lock TTL cannot release another instance's lock.
+var releaseLock = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+
+// RecordAccess bumps the pending lookup count for the BIN prefix. Counts are
+// flushed to Mongo, which is the only source for ranking popular BINs.
+func (c *Cache) RecordAccess(ctx context.Context, prefix string) {
+	if err := c.rdb.HIncrBy(ctx, accessCountsKey, prefix, 1).Err(); err != nil {
+		log.Printf("failed to record access for %s: %v", prefix, err)
+	}
+}
+
+// FlushAccessCounts moves the pending counters from Redis into Mongo. The
+// hash is renamed before it is read so lookups made during the flush land in
+// a fresh hash, and a lock keeps gateway instances from flushing at the same
+// time. A hash left behind by an interrupted flush is retried under the same
+// batch ID, which storage uses to skip counters that were already applied.
+func (c *Cache) FlushAccessCounts(ctx context.Context, store *storage.Store) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	locked, err := c.rdb.SetNX(ctx, accessFlushLockKey, token, time.Minute).Result()
+	if err != nil || !locked {
+		return err
+	}
+	defer releaseLock.Run(context.Background(), c.rdb, []string{accessFlushLockKey}, token)
+
+	batch, err := c.flushBatch(ctx)
+	if err != nil || batch == nil {
+		return err
+	}
+
+	raw, err := c.rdb.HGetAll(ctx, accessCountsFlushingKey).Result()
+	if err != nil {
+		return err
+	}
+	counts := make(map[string]int64, len(raw))
+	for prefix, v := range raw {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			continue
+		}
+		counts[prefix] = n
+	}
+	if err := store.IncAccessCounts(ctx, batch.id, batch.at, counts); err != nil {
+		return err
+	}
+	return c.rdb.Del(ctx, accessCountsFlushingKey, accessFlushBatchKey).Err()
+}
+
+// flushBatch identifies the counters in the flushing hash. The start time is
+// kept with the ID so a retry after midnight UTC still targets the day the
+// first attempt did.
+type flushBatch struct {
+	id string
+	at time.Time
+}
+
+// flushBatch returns the batch to flush, moving the pending hash into place
+// first unless an interrupted flush left one behind. It returns nil when
+// there is nothing to flush.
+func (c *Cache) flushBatch(ctx context.Context) (*flushBatch, error) {
+	leftover, err := c.rdb.Exists(ctx, accessCountsFlushingKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	id, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	at := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	if leftover == 0 {
+		pending, err := c.rdb.Exists(ctx, accessCountsKey).Result()
+		if err != nil || pending == 0 {
+			return nil, err
+		}
+		_, err = c.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Rename(ctx, accessCountsKey, accessCountsFlushingKey)
+			pipe.Del(ctx, accessFlushBatchKey)
+			pipe.HSet(ctx, accessFlushBatchKey, "id", id, "at", at)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// HSetNX keeps the batch of the interrupted flush when there is one.
+		_, err = c.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSetNX(ctx, accessFlushBatchKey, "id", id)
+			pipe.HSetNX(ctx, accessFlushBatchKey, "at", at)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fields, err := c.rdb.HGetAll(ctx, accessFlushBatchKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	ms, err := strconv.ParseInt(fields["at"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access flush batch time %q: %w", fields["at"], err)
+	}
+	return &flushBatch{id: fields["id"], at: time.UnixMilli(ms).UTC()}, nil
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// RunAccessFlusher calls FlushAccessCounts every AccessFlushInterval until
+// ctx is done.
+func (c *Cache) RunAccessFlusher(ctx context.Context, store *storage.Store) {
+	if c.cfg.AccessFlushInterval <= 0 {
+		log.Printf("not flushing access counts: invalid interval %v", c.cfg.AccessFlushInterval)
+		return
+	}
+	ticker := time.NewTicker(c.cfg.AccessFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := c.FlushAccessCounts(ctx, store); err != nil {
+			log.Printf("failed to flush access counts: %v", err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Package cache is the Redis hot cache in front of storage, together with
+// the per-BIN access counters that decide which records stay pinned in it.
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lari4/bin-lookup-gateway/config"
+	"github.com/lari4/bin-lookup-gateway/storage"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log"
+	"time"
+)
+
+const (
+	hotCachePrefix = "bin-lookup-gateway:bin:"
+	// pinnedKey is the set of BIN prefixes whose hot cache entries never expire.
+	pinnedKey = "bin-lookup-gateway:pinned"
+)
+
+// ErrMiss is returned by Get when the prefix is not cached.
+var ErrMiss = errors.New("cache miss")
+
+// NewClient opens and pings a Redis connection configured by cfg.
+func NewClient(ctx context.Context, cfg config.Redis) (*redis.Client, error) {
+	opts := &redis.Options{}
+	if cfg.URL != "" {
+		var err error
+		if opts, err = redis.ParseURL(cfg.URL); err != nil {
+			return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+		}
+	} else {
+		port := cfg.Port
+		if port == 0 {
+			port = 6379
+		}
+		opts.Addr = fmt.Sprintf("%s:%d", cfg.Host, port)
+	}
+	if cfg.PoolSize != 0 {
+		opts.PoolSize = cfg.PoolSize
+	}
+	if cfg.MinIdleConns != 0 {
+		opts.MinIdleConns = cfg.MinIdleConns
+	}
+	if cfg.MaxIdleConns != 0 {
+		opts.MaxIdleConns = cfg.MaxIdleConns
+	}
+	if cfg.ConnMaxIdleTime != 0 {
+		opts.ConnMaxIdleTime = cfg.ConnMaxIdleTime
+	}
+	if cfg.PoolTimeout != 0 {
+		opts.PoolTimeout = cfg.PoolTimeout
+	}
+	rdb := redis.NewClient(opts)
+	pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := rdb.Ping(pingCtx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	return rdb, nil
+}
+
+// Cache is the hot cache. The Redis client may be shared with the rate
+// limiter and the change feed.
+type Cache struct {
+	rdb *redis.Client
+	cfg config.HotCache
+}
+
+// New returns a Cache on an existing Redis client, e.g. one from NewClient.
+func New(rdb *redis.Client, cfg config.HotCache) *Cache {
+	return &Cache{rdb: rdb, cfg: cfg}
+}
+
+// Get returns the cached record for prefix, or ErrMiss.
+func (c *Cache) Get(ctx context.Context, prefix string) (*storage.BinData, error) {
+	raw, err := c.rdb.Get(ctx, hotCachePrefix+prefix).Bytes()
+	if err == redis.Nil {
+		return nil, ErrMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	var binData storage.BinData
+	if err := json.Unmarshal(raw, &binData); err != nil {
+		return nil, err
+	}
+	return &binData, nil
+}
+
+// Set stores the record in the hot cache. Pinned prefixes are stored
+// without a TTL so a refill after eviction does not unpin them.
+func (c *Cache) Set(ctx context.Context, prefix string, binData *storage.BinData) error {
+	ttl := c.cfg.TTL
+	pinned, err := c.rdb.SIsMember(ctx, pinnedKey, prefix).Result()
+	if err != nil {
+		return err
+	}
+	if pinned {
+		ttl = 0
+	}
+	raw, err := json.Marshal(binData)
+	if err != nil {
+		return err
+	}
+	return c.rdb.Set(ctx, hotCachePrefix+prefix, raw, ttl).Err()
+}
+
+// RefreshPopular reloads the top-N BINs requested most over the last
+// PopularWindow, as flushed to storage, into the hot cache without a TTL, so
+// they are always answered from Redis. Prefixes that fell out of the top-N
+// get the regular TTL back. Prefixes without a stored record are skipped;
+// refreshing never calls the upstream provider. Storage errors abort the
+// refresh so pins are not dropped during an outage.
+func (c *Cache) RefreshPopular(ctx context.Context, store *storage.Store) error {
+	top, err := store.Popular(ctx, time.Now().Add(-c.cfg.PopularWindow), int64(c.cfg.PopularTopN))
+	if err != nil {
+		return err
+	}
+	pinned := make(map[string]bool, len(top))
+	for _, count := range top {
+		prefix := count.BinNumber
+		binData, err := store.FindBIN(ctx, prefix)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		raw, err := json.Marshal(binData)
+		if err != nil {
+			return err
+		}
+		_, err = c.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, hotCachePrefix+prefix, raw, 0)
+			pipe.SAdd(ctx, pinnedKey, prefix)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		pinned[prefix] = true
+	}
+
+	previous, err := c.rdb.SMembers(ctx, pinnedKey).Result()
+	if err != nil {
+		return err
+	}
+	for _, prefix := range previous {
+		if pinned[prefix] {
+			continue
+		}
+		_, err = c.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SRem(ctx, pinnedKey, prefix)
+			pipe.Expire(ctx, hotCachePrefix+prefix, c.cfg.TTL)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunPopularRefresher calls RefreshPopular every PopularRefreshInterval
+// until ctx is done. It is a no-op when PopularTopN is 0.
+func (c *Cache) RunPopularRefresher(ctx context.Context, store *storage.Store) {
+	if c.cfg.PopularTopN <= 0 {
+		return
+	}
+	if c.cfg.PopularRefreshInterval <= 0 {
+		log.Printf("not refreshing popular BINs: invalid interval %v", c.cfg.PopularRefreshInterval)
+		return
+	}
+	ticker := time.NewTicker(c.cfg.PopularRefreshInterval)
+	defer ticker.Stop()
+	for {
+		if err := c.RefreshPopular(ctx, store); err != nil {
+			log.Printf("failed to refresh popular BINs: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
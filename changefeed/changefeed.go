@@ -0,0 +1,224 @@
+// Package changefeed publishes changes to stored BIN records to a
+// downstream sink.
+package changefeed
+
+import (
+	"context"
+	"fmt"
+	"github.com/lari4/bin-lookup-gateway/config"
+	"github.com/lari4/bin-lookup-gateway/storage"
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpCreate is the op of events for newly saved records.
+const OpCreate = "create"
+
+const (
+	// maxBatch is the most events handed to the sink in one Publish call.
+	maxBatch = 100
+	// A failed batch is retried with exponential backoff, starting at
+	// minBackoff and capped at maxBackoff, before it is dropped.
+	maxAttempts = 5
+	minBackoff  = 100 * time.Millisecond
+	maxBackoff  = 5 * time.Second
+	// sinkTimeout bounds a single write to the sink: one Kafka or Redis
+	// batch, or one webhook request.
+	sinkTimeout = 10 * time.Second
+)
+
+// Event describes a single change to a stored BIN record. Delivery is at
+// least once, so consumers should deduplicate on ID.
+type Event struct {
+	ID         string           `json:"id"`
+	Op         string           `json:"op"`
+	BinNumber  string           `json:"bin"`
+	Record     *storage.BinData `json:"record,omitempty"`
+	OccurredAt time.Time        `json:"occurred_at"`
+}
+
+// Sink delivers change events to a downstream system. Events are passed in
+// batches, in the order they occurred. Publish returns how many leading
+// events were delivered, so a failed batch is retried from the first one
+// that was not. Sinks apply their own write timeouts.
+type Sink interface {
+	Publish(ctx context.Context, events []*Event) (int, error)
+	// Close flushes and releases the sink once Run has returned.
+	Close() error
+}
+
+// Feed publishes events to its sink in the background; see Run and Close.
+type Feed struct {
+	sink   Sink
+	events chan *Event
+	done   chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// New returns a feed publishing to the sink selected by cfg.Sink, or nil
+// when no sink is configured. The Redis stream sink uses rdb.
+func New(cfg config.ChangeFeed, rdb *redis.Client) (*Feed, error) {
+	var sink Sink
+	switch cfg.Sink {
+	case "":
+		return nil, nil
+	case "kafka":
+		brokers := strings.Split(cfg.KafkaBrokers, ",")
+		if brokers[0] == "" || cfg.KafkaTopic == "" {
+			return nil, fmt.Errorf("CHANGE_FEED_KAFKA_BROKERS and CHANGE_FEED_KAFKA_TOPIC are required for the kafka change feed")
+		}
+		sink = &kafkaSink{writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        cfg.KafkaTopic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			// WriteMessages waits for a full batch or BatchTimeout, which
+			// defaults to a second. Run already batches what is queued.
+			BatchSize:    maxBatch,
+			BatchTimeout: 10 * time.Millisecond,
+		}}
+	case "webhook":
+		if cfg.WebhookURL == "" {
+			return nil, fmt.Errorf("CHANGE_FEED_WEBHOOK_URL is required for the webhook change feed")
+		}
+		sink = &webhookSink{
+			client: &http.Client{Timeout: sinkTimeout},
+			url:    cfg.WebhookURL,
+			secret: cfg.WebhookSecret,
+		}
+	case "redis":
+		sink = &redisStreamSink{rdb: rdb, stream: cfg.RedisStream, maxLen: cfg.RedisMaxLen}
+	default:
+		return nil, fmt.Errorf("invalid CHANGE_FEED_SINK: %q", cfg.Sink)
+	}
+	return NewWithSink(sink, cfg.Buffer), nil
+}
+
+// NewWithSink returns a feed that queues up to buffer events for sink.
+// buffer must be at least 1, since Publish never blocks.
+func NewWithSink(sink Sink, buffer int) *Feed {
+	return &Feed{sink: sink, events: make(chan *Event, buffer), done: make(chan struct{})}
+}
+
+// Run publishes queued events in batches of up to maxBatch, preserving
+// their order, until Close is called and the queue is drained. Cancelling
+// ctx abandons the events still queued.
+func (f *Feed) Run(ctx context.Context) {
+	defer close(f.done)
+	batch := make([]*Event, 0, maxBatch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-f.events:
+			if !ok {
+				return
+			}
+			batch = append(batch, event)
+		}
+	drain:
+		for len(batch) < maxBatch {
+			select {
+			case event, ok := <-f.events:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, event)
+			default:
+				break drain
+			}
+		}
+		if err := f.publish(ctx, batch); err != nil {
+			log.Printf("dropping changes after retries: %v", err)
+		}
+		batch = batch[:0]
+	}
+}
+
+// publish hands batch to the sink, retrying the undelivered events with
+// backoff. Attempts are counted from the last one that made progress.
+func (f *Feed) publish(ctx context.Context, batch []*Event) error {
+	backoff := minBackoff
+	for attempt := 1; ; attempt++ {
+		n, err := f.sink.Publish(ctx, batch)
+		if err == nil {
+			return nil
+		}
+		if n > 0 {
+			batch = batch[n:]
+			attempt, backoff = 1, minBackoff
+		}
+		if attempt == maxAttempts {
+			return err
+		}
+		log.Printf("failed to publish %d changes, retrying in %v: %v", len(batch), backoff, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// Close stops accepting events, waits for Run to publish the ones already
+// queued and closes the sink. If ctx ends first, the remaining events are
+// abandoned and ctx's error is returned. Run must have been started.
+func (f *Feed) Close(ctx context.Context) error {
+	f.mu.Lock()
+	if !f.closed {
+		f.closed = true
+		close(f.events)
+	}
+	f.mu.Unlock()
+
+	var err error
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if closeErr := f.sink.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Publish queues a change event without blocking the caller. Events are
+// dropped, and logged, when the buffer is full, when the sink keeps failing
+// or after Close; size CHANGE_FEED_BUFFER for bursts. GET /v1/changes stays
+// the complete record for consumers that cannot miss a change. Publishing to
+// a nil feed is a no-op.
+func (f *Feed) Publish(op string, binData *storage.BinData) {
+	if f == nil {
+		return
+	}
+	event := &Event{
+		ID:         primitive.NewObjectID().Hex(),
+		Op:         op,
+		BinNumber:  binData.BinNumber,
+		Record:     binData,
+		OccurredAt: time.Now().UTC(),
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed {
+		log.Printf("change feed closed, dropping %s change for %s", op, binData.BinNumber)
+		return
+	}
+	select {
+	case f.events <- event:
+	default:
+		log.Printf("change feed buffer full, dropping %s change for %s", op, binData.BinNumber)
+	}
+}
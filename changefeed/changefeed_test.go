@@ -0,0 +1,84 @@
+package changefeed
+
+import (
+	"context"
+	"errors"
+	"github.com/lari4/bin-lookup-gateway/storage"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakySink delivers at most perCall events per Publish and then fails,
+// until failures runs out.
+type flakySink struct {
+	mu        sync.Mutex
+	perCall   int
+	failures  int
+	delivered []*Event
+	closed    bool
+}
+
+func (s *flakySink) Publish(ctx context.Context, events []*Event) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures == 0 {
+		s.delivered = append(s.delivered, events...)
+		return len(events), nil
+	}
+	s.failures--
+	n := s.perCall
+	if n > len(events) {
+		n = len(events)
+	}
+	s.delivered = append(s.delivered, events[:n]...)
+	return n, errors.New("timeout")
+}
+
+func (s *flakySink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestRetryResendsOnlyUndelivered(t *testing.T) {
+	// More failures than maxAttempts: each one makes progress, so the
+	// batch is not dropped.
+	sink := &flakySink{perCall: 10, failures: maxAttempts + 2}
+	feed := NewWithSink(sink, maxBatch)
+	for i := 0; i < maxBatch; i++ {
+		feed.Publish(OpCreate, &storage.BinData{BinNumber: "547927"})
+	}
+	go feed.Run(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := feed.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sink.delivered) != maxBatch {
+		t.Fatalf("delivered %d events, want %d", len(sink.delivered), maxBatch)
+	}
+	seen := make(map[string]bool, maxBatch)
+	for _, event := range sink.delivered {
+		if seen[event.ID] {
+			t.Fatalf("event %s delivered twice", event.ID)
+		}
+		seen[event.ID] = true
+	}
+	if !sink.closed {
+		t.Fatal("sink not closed")
+	}
+}
+
+func TestPublishAfterCloseIsDropped(t *testing.T) {
+	sink := &flakySink{}
+	feed := NewWithSink(sink, 1)
+	go feed.Run(context.Background())
+	if err := feed.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	feed.Publish(OpCreate, &storage.BinData{BinNumber: "547927"})
+	if len(sink.delivered) != 0 {
+		t.Fatalf("delivered %d events after Close", len(sink.delivered))
+	}
+}
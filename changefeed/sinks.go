@@ -0,0 +1,156 @@
+package changefeed
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"net/http"
+)
+
+type kafkaSink struct {
+	writer *kafka.Writer
+}
+
+func (s *kafkaSink) Publish(ctx context.Context, events []*Event) (int, error) {
+	messages := make([]kafka.Message, 0, len(events))
+	var encodeErr error
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			// Deliver what precedes the bad event, then report it.
+			encodeErr = err
+			break
+		}
+		// Keying by BIN keeps the events of a record in one partition, in order.
+		messages = append(messages, kafka.Message{
+			Key:   []byte(event.BinNumber),
+			Value: payload,
+		})
+	}
+	if len(messages) == 0 {
+		return 0, encodeErr
+	}
+	ctx, cancel := context.WithTimeout(ctx, sinkTimeout)
+	defer cancel()
+	err := s.writer.WriteMessages(ctx, messages...)
+	var writeErrs kafka.WriteErrors
+	if errors.As(err, &writeErrs) {
+		return delivered(writeErrs), err
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(messages), encodeErr
+}
+
+// delivered counts the leading nil entries of per-event errors.
+func delivered(errs []error) int {
+	for i, err := range errs {
+		if err != nil {
+			return i
+		}
+	}
+	return len(errs)
+}
+
+func (s *kafkaSink) Close() error {
+	return s.writer.Close()
+}
+
+// webhookSink POSTs each event as its own signed request, each bounded by
+// the client's timeout.
+type webhookSink struct {
+	client *http.Client
+	url    string
+	secret string
+}
+
+func (s *webhookSink) Publish(ctx context.Context, events []*Event) (int, error) {
+	for i, event := range events {
+		if err := s.post(ctx, event); err != nil {
+			return i, err
+		}
+	}
+	return len(events), nil
+}
+
+func (s *webhookSink) post(ctx context.Context, event *Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.secret))
+		mac.Write(payload)
+		req.Header.Set("X-Signature-SHA256", hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *webhookSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+type redisStreamSink struct {
+	rdb    *redis.Client
+	stream string
+	maxLen int64
+}
+
+func (s *redisStreamSink) Publish(ctx context.Context, events []*Event) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, sinkTimeout)
+	defer cancel()
+	var encodeErr error
+	cmds, err := s.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, event := range events {
+			payload, err := json.Marshal(event)
+			if err != nil {
+				// Deliver what precedes the bad event, then report it.
+				encodeErr = err
+				break
+			}
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: s.stream,
+				MaxLen: s.maxLen,
+				Approx: true,
+				Values: map[string]interface{}{"op": event.Op, "bin": event.BinNumber, "event": payload},
+			})
+		}
+		return nil
+	})
+	errs := make([]error, len(cmds))
+	for i, cmd := range cmds {
+		errs[i] = cmd.Err()
+	}
+	n := delivered(errs)
+	if err != nil {
+		return n, err
+	}
+	return n, encodeErr
+}
+
+// Close leaves rdb open; it is shared with the rest of the gateway.
+func (s *redisStreamSink) Close() error {
+	return nil
+}
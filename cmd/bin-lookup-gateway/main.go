@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/getsentry/sentry-go"
+	"github.com/lari4/bin-lookup-gateway/cache"
+	"github.com/lari4/bin-lookup-gateway/changefeed"
+	"github.com/lari4/bin-lookup-gateway/config"
+	"github.com/lari4/bin-lookup-gateway/lookup"
+	"github.com/lari4/bin-lookup-gateway/providers"
+	"github.com/lari4/bin-lookup-gateway/ratelimit"
+	"github.com/lari4/bin-lookup-gateway/server"
+	"github.com/lari4/bin-lookup-gateway/storage"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	flag.BoolVar(&cfg.CacheOnly, "cache-only", cfg.CacheOnly, "serve only from Redis and Mongo, never call the upstream provider (overrides CACHE_ONLY)")
+	flag.Parse()
+	if err := sentry.Init(sentry.ClientOptions{
+		Dsn:              cfg.SentryDSN,
+		EnableTracing:    true,
+		TracesSampleRate: 1.0,
+	}); err != nil {
+		fmt.Printf("Sentry initialization failed: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	store, err := storage.Connect(ctx, cfg.Mongo)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		if err := store.Disconnect(context.Background()); err != nil {
+			log.Fatalf("Error on disconnection with MongoDB: %v", err)
+		}
+	}()
+	fmt.Println("Connected to MongoDB!")
+
+	indexCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if err := store.EnsureIndexes(indexCtx); err != nil {
+		log.Fatal(err)
+	}
+
+	rdb, err := cache.NewClient(ctx, cfg.Redis)
+	if err != nil {
+		panic(err)
+	}
+	hotCache := cache.New(rdb, cfg.HotCache)
+
+	rules, err := ratelimit.ParseRules(cfg.RateLimit.Callers)
+	if err != nil {
+		log.Fatalf("Invalid RATE_LIMIT_CALLERS entry: %v", err)
+	}
+
+	feed, err := changefeed.New(cfg.ChangeFeed, rdb)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if feed != nil {
+		// The feed outlives ctx so events queued before shutdown are
+		// delivered by feed.Close below.
+		go feed.Run(context.Background())
+	}
+
+	go hotCache.RunPopularRefresher(ctx, store)
+	go hotCache.RunAccessFlusher(ctx, store)
+
+	service := &lookup.Service{
+		Store:     store,
+		Cache:     hotCache,
+		Provider:  providers.NewNeutrino(providers.NewHTTPClient(cfg.Upstream), cfg.Upstream.URL, cfg.Upstream.UserID, cfg.Upstream.APIKey),
+		Limiter:   ratelimit.New(rdb, rules),
+		Feed:      feed,
+		CacheOnly: cfg.CacheOnly,
+	}
+	if cfg.CacheOnly {
+		log.Println("Cache-only mode: upstream provider requests are disabled")
+	}
+	handler := server.New(service, server.Options{
+		AdminAPIKey:       cfg.AdminAPIKey,
+		TrustForwardedFor: cfg.RateLimit.TrustForwardedFor,
+	})
+
+	srv := &http.Server{Addr: cfg.Addr, Handler: handler}
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		log.Println("Shutting down...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("HTTP server shutdown: %v", err)
+		}
+	}()
+
+	log.Printf("Server starting on port %s...", cfg.Addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		panic(err)
+	}
+	// Shutdown returns once in-flight lookups are done publishing.
+	<-shutdown
+
+	if feed != nil {
+		closeCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := feed.Close(closeCtx); err != nil {
+			log.Printf("Change feed shutdown: %v", err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Package lookup is the BIN lookup pipeline: the hot cache, then storage,
+// then the rate limited upstream provider. It can be embedded in other
+// services to look BINs up in-process instead of through the gateway.
+package lookup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis_rate/v10"
+	"github.com/lari4/bin-lookup-gateway/cache"
+	"github.com/lari4/bin-lookup-gateway/changefeed"
+	"github.com/lari4/bin-lookup-gateway/providers"
+	"github.com/lari4/bin-lookup-gateway/ratelimit"
+	"github.com/lari4/bin-lookup-gateway/storage"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log"
+	"unicode"
+)
+
+// Errors returned by Service.Lookup.
+var (
+	ErrInvalidBIN = errors.New("invalid BIN number")
+	ErrNotFound   = errors.New("no data found for this BIN/IIN number")
+)
+
+// RateLimitedError is returned when the caller's upstream bucket is empty.
+type RateLimitedError struct {
+	Result *redis_rate.Result
+}
+
+func (e *RateLimitedError) Error() string {
+	return "rate limit exceeded"
+}
+
+// Service looks BINs up. Store is required, and so is Provider unless
+// CacheOnly is set; a nil Cache, Limiter or Feed disables that stage.
+type Service struct {
+	Store    *storage.Store
+	Cache    *cache.Cache
+	Provider providers.Provider
+	Limiter  *ratelimit.Limiter
+	Feed     *changefeed.Feed
+	// CacheOnly makes BINs missing from the cache and storage ErrNotFound
+	// instead of an upstream request, so nothing is spent on the provider.
+	// Storage errors are returned as they are rather than as ErrNotFound.
+	// Stored records are served however old they are.
+	CacheOnly bool
+}
+
+// IsValidBIN reports whether number is at least six digits long and
+// contains only digits.
+func IsValidBIN(number string) bool {
+	if len(number) < 6 {
+		return false
+	}
+
+	for _, r := range number {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Lookup returns the data for bin, calling the provider only when neither
+// the cache nor storage has it. Upstream requests are charged to caller's
+// rate limit bucket. It returns ErrInvalidBIN, ErrNotFound or a
+// *RateLimitedError for the corresponding outcomes.
+func (s *Service) Lookup(ctx context.Context, bin string, caller ratelimit.Caller) (*storage.BinData, error) {
+	if !IsValidBIN(bin) {
+		return nil, ErrInvalidBIN
+	}
+	prefix := storage.BinPrefix(bin)
+	if s.Cache != nil {
+		s.Cache.RecordAccess(ctx, prefix)
+		binData, err := s.Cache.Get(ctx, prefix)
+		if err == nil {
+			return binData, nil
+		}
+		if err != cache.ErrMiss {
+			log.Printf("failed to read hot cache: %v", err)
+		}
+	}
+
+	binData, err := s.Store.FindBIN(ctx, bin)
+	if err == nil {
+		s.cache(ctx, prefix, binData)
+		return binData, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		if s.CacheOnly {
+			// Without the upstream fallback a storage outage is not a miss.
+			return nil, fmt.Errorf("failed to read data from DB: %w", err)
+		}
+		log.Printf("failed to read data from DB: %v", err)
+	}
+	if s.CacheOnly {
+		return nil, ErrNotFound
+	}
+
+	if s.Limiter != nil {
+		res, err := s.Limiter.Allow(ctx, caller)
+		if err != nil {
+			return nil, err
+		}
+		if res != nil && res.Allowed == 0 {
+			return nil, &RateLimitedError{Result: res}
+		}
+	}
+	binData, err = s.Provider.Lookup(ctx, bin)
+	if err != nil {
+		if err != providers.ErrNotFound {
+			log.Printf("upstream lookup failed: %v", err)
+		}
+		return nil, ErrNotFound
+	}
+	if binData.BinNumber == "" {
+		binData.BinNumber = prefix
+	}
+	if err := s.Store.SaveBIN(ctx, binData); err != nil {
+		log.Printf("failed to save data to DB: %v", err)
+	} else {
+		s.Feed.Publish(changefeed.OpCreate, binData)
+	}
+	s.cache(ctx, prefix, binData)
+	return binData, nil
+}
+
+func (s *Service) cache(ctx context.Context, prefix string, binData *storage.BinData) {
+	if s.Cache == nil {
+		return
+	}
+	if err := s.Cache.Set(ctx, prefix, binData); err != nil {
+		log.Printf("failed to save data to hot cache: %v", err)
+	}
+}
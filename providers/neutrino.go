@@ -0,0 +1,67 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"github.com/lari4/bin-lookup-gateway/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// Neutrino is the neutrinoapi.net BIN lookup API.
+type Neutrino struct {
+	client *http.Client
+	url    string
+	userID string
+	apiKey string
+}
+
+// NewNeutrino returns a provider calling reqURL with the account's
+// credentials, e.g. with a client from NewHTTPClient.
+func NewNeutrino(client *http.Client, reqURL, userID, apiKey string) *Neutrino {
+	return &Neutrino{client: client, url: reqURL, userID: userID, apiKey: apiKey}
+}
+
+// Lookup requests bin from the API. It returns ErrNotFound when the API has
+// no data for it.
+func (n *Neutrino) Lookup(ctx context.Context, bin string) (*storage.BinData, error) {
+	params := url.Values{}
+	params.Add("bin-number", bin)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", n.url+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Add("user-id", n.userID)
+	req.Header.Add("api-key", n.apiKey)
+	req.Header.Add("Accept", "application/json")
+
+	log.Printf("Requesting data for BIN/IIN number: %s", bin)
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("received non-200 response: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	var binData *storage.BinData
+
+	err = bson.UnmarshalExtJSON(body, true, &binData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response body: %w", err)
+	}
+	if binData == nil {
+		return nil, ErrNotFound
+	}
+	return binData, nil
+}
@@ -0,0 +1,38 @@
+// Package providers fetches BIN data from paid upstream APIs.
+package providers
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/lari4/bin-lookup-gateway/config"
+	"github.com/lari4/bin-lookup-gateway/storage"
+	"net/http"
+)
+
+// ErrNotFound is returned when the provider has no data for a BIN.
+var ErrNotFound = errors.New("no data found for this BIN/IIN number")
+
+// Provider looks up a BIN number upstream. Each call may be billed.
+type Provider interface {
+	Lookup(ctx context.Context, bin string) (*storage.BinData, error)
+}
+
+// NewHTTPClient builds the HTTP client used for provider requests. The
+// stock transport keeps only two idle connections per host, which forces new
+// TLS handshakes under load, so the pool is sized from cfg.
+func NewHTTPClient(cfg config.Upstream) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = cfg.MaxIdleConns
+	transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	transport.MaxConnsPerHost = cfg.MaxConnsPerHost
+	transport.IdleConnTimeout = cfg.IdleConnTimeout
+	transport.DisableKeepAlives = cfg.DisableKeepAlives
+	transport.TLSClientConfig = &tls.Config{
+		ClientSessionCache: tls.NewLRUClientSessionCache(cfg.TLSSessionCacheSize),
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   cfg.Timeout,
+	}
+}
@@ -0,0 +1,127 @@
+// Package ratelimit paces upstream provider requests per caller.
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"github.com/go-redis/redis_rate/v10"
+	"github.com/redis/go-redis/v9"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const defaultBucket = "bin-lookup-gateway"
+
+var defaultLimit = redis_rate.PerSecond(100)
+
+// Caller identifies who a lookup is made for.
+type Caller struct {
+	APIKey string
+	IP     net.IP
+}
+
+// Rule matches callers by API key or source network and assigns them a rate
+// limit policy other than the shared bucket.
+type Rule struct {
+	apiKey  string
+	network *net.IPNet
+	// exempt callers skip rate limiting entirely.
+	exempt bool
+	// bucket and limit hold the dedicated bucket of non-exempt callers.
+	bucket string
+	limit  redis_rate.Limit
+}
+
+// ParseRules parses a semicolon separated list of match=policy pairs where
+// match is an API key, a CIDR or a single IP address, and policy is "exempt"
+// or a number of upstream requests per second for a dedicated bucket, e.g.
+// "10.20.0.0/16=exempt;10.0.0.5=exempt;batch-key=20".
+func ParseRules(s string) ([]Rule, error) {
+	var rules []Rule
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		rule, err := parseRule(entry)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseRule(entry string) (Rule, error) {
+	match, policy, ok := strings.Cut(entry, "=")
+	if !ok || match == "" {
+		return Rule{}, fmt.Errorf("%q is not match=policy", entry)
+	}
+	var rule Rule
+	if _, network, err := net.ParseCIDR(match); err == nil {
+		rule.network = network
+	} else if ip := net.ParseIP(match); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		rule.network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	} else {
+		rule.apiKey = match
+	}
+	if policy == "exempt" {
+		rule.exempt = true
+		return rule, nil
+	}
+	rate, err := strconv.Atoi(policy)
+	if err != nil || rate < 1 {
+		return Rule{}, fmt.Errorf("%q: policy must be exempt or a positive rate", entry)
+	}
+	// Hash the match so API keys do not end up in Redis key names.
+	sum := sha256.Sum256([]byte(match))
+	rule.bucket = defaultBucket + ":caller:" + hex.EncodeToString(sum[:8])
+	rule.limit = redis_rate.PerSecond(rate)
+	return rule, nil
+}
+
+// Limiter enforces the upstream rate limit buckets.
+type Limiter struct {
+	limiter *redis_rate.Limiter
+	rules   []Rule
+}
+
+// New returns a limiter that gives callers matching rules their own policy;
+// everyone else shares the default bucket. The first matching rule wins.
+func New(rdb *redis.Client, rules []Rule) *Limiter {
+	return &Limiter{limiter: redis_rate.NewLimiter(rdb), rules: rules}
+}
+
+func (l *Limiter) match(caller Caller) *Rule {
+	for i := range l.rules {
+		rule := &l.rules[i]
+		if rule.apiKey != "" && subtle.ConstantTimeCompare([]byte(rule.apiKey), []byte(caller.APIKey)) == 1 {
+			return rule
+		}
+		if rule.network != nil && caller.IP != nil && rule.network.Contains(caller.IP) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Allow takes one upstream request from the caller's bucket. It returns a
+// nil result for exempt callers.
+func (l *Limiter) Allow(ctx context.Context, caller Caller) (*redis_rate.Result, error) {
+	bucket, limit := defaultBucket, defaultLimit
+	if rule := l.match(caller); rule != nil {
+		if rule.exempt {
+			return nil, nil
+		}
+		bucket, limit = rule.bucket, rule.limit
+	}
+	return l.limiter.Allow(ctx, bucket, limit)
+}
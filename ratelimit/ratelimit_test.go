@@ -0,0 +1,70 @@
+package ratelimit
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules(" 10.20.0.0/16=exempt; batch-key=20 ;10.0.0.5=exempt;2001:db8::1=5;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 4 {
+		t.Fatalf("got %d rules, want 4", len(rules))
+	}
+	if rules[0].network.String() != "10.20.0.0/16" || !rules[0].exempt {
+		t.Errorf("CIDR rule: %+v", rules[0])
+	}
+	if rules[1].apiKey != "batch-key" || rules[1].exempt || rules[1].limit.Rate != 20 {
+		t.Errorf("API key rule: %+v", rules[1])
+	}
+	if rules[2].network.String() != "10.0.0.5/32" || !rules[2].exempt {
+		t.Errorf("IPv4 address rule: %+v", rules[2])
+	}
+	if rules[3].network.String() != "2001:db8::1/128" || rules[3].limit.Rate != 5 {
+		t.Errorf("IPv6 address rule: %+v", rules[3])
+	}
+	if rules[1].bucket == rules[3].bucket || rules[1].bucket == defaultBucket {
+		t.Errorf("dedicated buckets not distinct: %q, %q", rules[1].bucket, rules[3].bucket)
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	for _, s := range []string{
+		"no-policy",
+		"=exempt",
+		"key=0",
+		"key=-3",
+		"key=fast",
+	} {
+		if _, err := ParseRules(s); err == nil {
+			t.Errorf("ParseRules(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	rules, err := ParseRules("internal-key=exempt;10.0.0.5=exempt;10.20.0.0/16=20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &Limiter{rules: rules}
+	tests := []struct {
+		name   string
+		caller Caller
+		want   *Rule
+	}{
+		{"API key", Caller{APIKey: "internal-key", IP: net.ParseIP("192.0.2.1")}, &l.rules[0]},
+		{"API key prefix", Caller{APIKey: "internal"}, nil},
+		{"single IP", Caller{IP: net.ParseIP("10.0.0.5")}, &l.rules[1]},
+		{"next IP", Caller{IP: net.ParseIP("10.0.0.6")}, nil},
+		{"network", Caller{IP: net.ParseIP("10.20.3.4")}, &l.rules[2]},
+		{"no IP", Caller{}, nil},
+	}
+	for _, tt := range tests {
+		if got := l.match(tt.caller); got != tt.want {
+			t.Errorf("%s: matched %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"crypto/subtle"
+	"github.com/lari4/bin-lookup-gateway/storage"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// adminOnly rejects requests that do not carry the admin API key in the
+// X-Admin-Key header. Admin endpoints are disabled while the key is unset.
+func (s *Server) adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := s.opts.AdminAPIKey
+		if key == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Key")), []byte(key)) != 1 {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// parseLimit reads the optional ?limit= parameter, which defaults to 100.
+func parseLimit(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return 100, true
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 1 || n > 1000 {
+		http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+		return 0, false
+	}
+	return n, true
+}
+
+// popularHandler serves GET /admin/popular?days=D&limit=N, ranking lookups
+// made over the last D days (7 by default), today included. Counts not yet
+// flushed from Redis are not included.
+func (s *Server) popularHandler(w http.ResponseWriter, r *http.Request) {
+	limit, ok := parseLimit(w, r)
+	if !ok {
+		return
+	}
+	days := 7
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 366 {
+			http.Error(w, "days must be between 1 and 366", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+	since := time.Now().UTC().AddDate(0, 0, 1-days)
+	popular, err := s.store.Popular(r.Context(), since, limit)
+	if err != nil {
+		log.Printf("failed to read access counts: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, popular, "Failed to encode access counts as JSON")
+}
+
+// changesHandler serves GET /v1/changes?since=<RFC 3339 timestamp>&limit=N,
+// and GET /v1/changes?cursor=<next_cursor>&limit=N for the following pages
+// and for polling once has_more is false. Records appear once they are older
+// than the settle window.
+func (s *Server) changesHandler(w http.ResponseWriter, r *http.Request) {
+	limit, ok := parseLimit(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	var since time.Time
+	var cursor *storage.ChangesCursor
+	if v := query.Get("cursor"); v != "" {
+		c, err := storage.ParseChangesCursor(v)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		cursor = &c
+	} else {
+		t, err := time.Parse(time.RFC3339Nano, query.Get("since"))
+		if err != nil {
+			http.Error(w, "since must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+
+	page, err := s.store.Changes(r.Context(), since, cursor, limit)
+	if err != nil {
+		log.Printf("failed to read changes: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, page, "Failed to encode changes as JSON")
+}
@@ -0,0 +1,707 @@
+//go:build integration
+
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/lari4/bin-lookup-gateway/cache"
+	"github.com/lari4/bin-lookup-gateway/changefeed"
+	"github.com/lari4/bin-lookup-gateway/config"
+	"github.com/lari4/bin-lookup-gateway/lookup"
+	"github.com/lari4/bin-lookup-gateway/providers"
+	"github.com/lari4/bin-lookup-gateway/ratelimit"
+	"github.com/lari4/bin-lookup-gateway/server"
+	"github.com/lari4/bin-lookup-gateway/storage"
+	"github.com/redis/go-redis/v9"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+const adminKey = "test-admin-key"
+
+// deps holds the containers shared by all tests. They are started by the
+// first test that needs them and terminated in TestMain.
+var deps struct {
+	once       sync.Once
+	err        error
+	containers []testcontainers.Container
+	mongo      config.Mongo
+	redis      config.Redis
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	for _, c := range deps.containers {
+		c.Terminate(context.Background())
+	}
+	os.Exit(code)
+}
+
+func startDependencies(ctx context.Context) error {
+	// A single node replica set rather than a standalone server, because
+	// the driver only sends read preferences to replica set members.
+	mongoC, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "mongo:6",
+			ExposedPorts: []string{"27017/tcp"},
+			Cmd:          []string{"--replSet", "rs0", "--bind_ip_all"},
+			WaitingFor:   wait.ForLog("Waiting for connections"),
+		},
+		Started: true,
+	})
+	if err != nil {
+		return err
+	}
+	deps.containers = append(deps.containers, mongoC)
+	if code, _, err := mongoC.Exec(ctx, []string{"mongosh", "--quiet", "--eval", "rs.initiate()"}); err != nil || code != 0 {
+		return fmt.Errorf("rs.initiate exited with %d: %v", code, err)
+	}
+
+	redisC, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "redis:7",
+			ExposedPorts: []string{"6379/tcp"},
+			WaitingFor:   wait.ForLog("Ready to accept connections"),
+		},
+		Started: true,
+	})
+	if err != nil {
+		return err
+	}
+	deps.containers = append(deps.containers, redisC)
+
+	mongoHost, err := mongoC.Host(ctx)
+	if err != nil {
+		return err
+	}
+	mongoPort, err := mongoC.MappedPort(ctx, "27017")
+	if err != nil {
+		return err
+	}
+	redisHost, err := redisC.Host(ctx)
+	if err != nil {
+		return err
+	}
+	redisPort, err := redisC.MappedPort(ctx, "6379")
+	if err != nil {
+		return err
+	}
+	deps.mongo = config.Mongo{
+		URI:         fmt.Sprintf("mongodb://%s:%d/?directConnection=true", mongoHost, mongoPort.Int()),
+		MaxPoolSize: 10,
+	}
+	deps.redis = config.Redis{Host: redisHost, Port: redisPort.Int()}
+	return waitForPrimary(ctx)
+}
+
+// waitForPrimary waits for the replica set election after rs.initiate.
+func waitForPrimary(ctx context.Context) error {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(deps.mongo.URI))
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(ctx)
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		var hello struct {
+			IsWritablePrimary bool `bson:"isWritablePrimary"`
+		}
+		err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+		if err == nil && hello.IsWritablePrimary {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("replica set has no primary: %v", err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// fakeProvider serves BIN data from memory and counts upstream calls.
+type fakeProvider struct {
+	mu    sync.Mutex
+	data  map[string]storage.BinData
+	err   error
+	calls int
+}
+
+func (p *fakeProvider) Lookup(ctx context.Context, bin string) (*storage.BinData, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	binData, ok := p.data[storage.BinPrefix(bin)]
+	if !ok {
+		return nil, providers.ErrNotFound
+	}
+	return &binData, nil
+}
+
+func (p *fakeProvider) Calls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+type harness struct {
+	server   *httptest.Server
+	store    *storage.Store
+	rdb      *redis.Client
+	cache    *cache.Cache
+	provider *fakeProvider
+}
+
+type harnessOptions struct {
+	rateLimitCallers    string
+	cacheOnly           bool
+	changesSettleWindow time.Duration
+	// changeStream, when set, publishes the change feed to this Redis stream.
+	changeStream string
+}
+
+// newHarness runs the gateway handler against empty Mongo and Redis
+// databases, with a fake provider that knows BIN 547927.
+func newHarness(t *testing.T, opts harnessOptions) *harness {
+	t.Helper()
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+	ctx := context.Background()
+	deps.once.Do(func() {
+		deps.err = startDependencies(ctx)
+	})
+	if deps.err != nil {
+		t.Fatalf("failed to start dependencies: %v", deps.err)
+	}
+
+	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(deps.mongo.URI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mongoClient.Database("bin-lookup-gateway").Drop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mongoClient.Disconnect(ctx)
+
+	mongoCfg := deps.mongo
+	mongoCfg.ChangesSettleWindow = opts.changesSettleWindow
+	store, err := storage.Connect(ctx, mongoCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Disconnect(context.Background()) })
+	if err := store.EnsureIndexes(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	rdb, err := cache.NewClient(ctx, deps.redis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rdb.Close() })
+	if err := rdb.FlushDB(ctx).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := ratelimit.ParseRules(opts.rateLimitCallers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := &fakeProvider{data: map[string]storage.BinData{
+		"547927": {BinNumber: "547927", Country: "RUSSIAN FEDERATION", CardBrand: "MASTERCARD", Valid: true},
+	}}
+	hotCache := cache.New(rdb, config.HotCache{TTL: time.Hour, PopularTopN: 10, PopularWindow: time.Hour})
+	var feed *changefeed.Feed
+	if opts.changeStream != "" {
+		feed, err = changefeed.New(config.ChangeFeed{Sink: "redis", Buffer: 10, RedisStream: opts.changeStream, RedisMaxLen: 100}, rdb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go feed.Run(context.Background())
+		t.Cleanup(func() { feed.Close(context.Background()) })
+	}
+	service := &lookup.Service{
+		Store:     store,
+		Cache:     hotCache,
+		Provider:  provider,
+		Limiter:   ratelimit.New(rdb, rules),
+		Feed:      feed,
+		CacheOnly: opts.cacheOnly,
+	}
+	srv := httptest.NewServer(server.New(service, server.Options{AdminAPIKey: adminKey}))
+	t.Cleanup(srv.Close)
+
+	return &harness{server: srv, store: store, rdb: rdb, cache: hotCache, provider: provider}
+}
+
+func (h *harness) get(t *testing.T, path string, header http.Header) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest("GET", h.server.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestLookupCachesUpstreamResult(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		resp, body := h.get(t, "/?bin=54792777", nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("lookup %d: status %d, body %s", i, resp.StatusCode, body)
+		}
+		var binData storage.BinData
+		if err := json.Unmarshal(body, &binData); err != nil {
+			t.Fatal(err)
+		}
+		if binData.BinNumber != "547927" || binData.CardBrand != "MASTERCARD" {
+			t.Fatalf("lookup %d: unexpected data %+v", i, binData)
+		}
+		if bytes.Contains(body, []byte("CreatedAt")) || bytes.Contains(body, []byte("UpdatedAt")) {
+			t.Fatalf("lookup %d: response exposes timestamps: %s", i, body)
+		}
+	}
+	if calls := h.provider.Calls(); calls != 1 {
+		t.Fatalf("provider called %d times, want 1", calls)
+	}
+
+	stored, err := h.store.FindBIN(ctx, "547927")
+	if err != nil {
+		t.Fatalf("record not saved to Mongo: %v", err)
+	}
+	if stored.CreatedAt.IsZero() || stored.UpdatedAt.IsZero() {
+		t.Fatalf("record saved without timestamps: %+v", stored)
+	}
+	if _, err := h.cache.Get(ctx, "547927"); err != nil {
+		t.Fatalf("record not saved to hot cache: %v", err)
+	}
+}
+
+func TestLookupFallsBackToStorage(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+
+	if resp, body := h.get(t, "/?bin=547927", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, body %s", resp.StatusCode, body)
+	}
+	if err := h.rdb.FlushDB(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if resp, body := h.get(t, "/?bin=547927", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d after cache flush, body %s", resp.StatusCode, body)
+	}
+	if calls := h.provider.Calls(); calls != 1 {
+		t.Fatalf("provider called %d times, want 1", calls)
+	}
+}
+
+func TestLookupErrors(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"too short", "/?bin=5479", http.StatusBadRequest},
+		{"not digits", "/?bin=54792a", http.StatusBadRequest},
+		{"missing", "/", http.StatusBadRequest},
+		{"unknown upstream", "/?bin=400000", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := h.get(t, tt.path, nil)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d, body %s", resp.StatusCode, tt.status, body)
+			}
+		})
+	}
+	if calls := h.provider.Calls(); calls != 1 {
+		t.Fatalf("provider called %d times, want 1 for the unknown BIN only", calls)
+	}
+}
+
+func TestLookupUpstreamFailure(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+	h.provider.err = fmt.Errorf("received non-200 response: 503")
+
+	resp, body := h.get(t, "/?bin=547927", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("status %d, want 404, body %s", resp.StatusCode, body)
+	}
+	if _, err := h.store.FindBIN(context.Background(), "547927"); err != mongo.ErrNoDocuments {
+		t.Fatalf("failed lookup was stored: %v", err)
+	}
+}
+
+func TestRateLimitDedicatedBucket(t *testing.T) {
+	h := newHarness(t, harnessOptions{rateLimitCallers: "batch-key=1"})
+	header := http.Header{"X-Api-Key": {"batch-key"}}
+
+	if resp, body := h.get(t, "/?bin=400000", header); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("first lookup: status %d, body %s", resp.StatusCode, body)
+	}
+	resp, body := h.get(t, "/?bin=400001", header)
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("second lookup: status %d, want 429, body %s", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "1" {
+		t.Fatalf("Retry-After %q, want 1", got)
+	}
+	var limited struct {
+		Limit        int   `json:"limit"`
+		Remaining    int   `json:"remaining"`
+		RetryAfterMs int64 `json:"retry_after_ms"`
+	}
+	if err := json.Unmarshal(body, &limited); err != nil {
+		t.Fatalf("429 body is not JSON: %v: %s", err, body)
+	}
+	if limited.Limit != 1 || limited.Remaining != 0 || limited.RetryAfterMs <= 0 || limited.RetryAfterMs > 1000 {
+		t.Fatalf("unexpected limiter state %+v", limited)
+	}
+	if calls := h.provider.Calls(); calls != 1 {
+		t.Fatalf("provider called %d times, want 1", calls)
+	}
+
+	// The dedicated bucket does not drain the shared one.
+	if resp, body := h.get(t, "/?bin=547927", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("shared bucket lookup: status %d, body %s", resp.StatusCode, body)
+	}
+}
+
+func TestRateLimitExemptCaller(t *testing.T) {
+	// Loopback callers, which is everyone in this test, get a 1/s bucket
+	// unless they present the exempt key.
+	h := newHarness(t, harnessOptions{rateLimitCallers: "internal-key=exempt;127.0.0.0/8=1"})
+
+	if resp, body := h.get(t, "/?bin=400000", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("first lookup: status %d, body %s", resp.StatusCode, body)
+	}
+	if resp, body := h.get(t, "/?bin=400001", nil); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("second lookup: status %d, want 429, body %s", resp.StatusCode, body)
+	}
+	resp, body := h.get(t, "/?bin=547927", http.Header{"X-Api-Key": {"internal-key"}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("exempt lookup: status %d, body %s", resp.StatusCode, body)
+	}
+}
+
+func TestCacheOnlyNeverCallsUpstream(t *testing.T) {
+	h := newHarness(t, harnessOptions{cacheOnly: true})
+	ctx := context.Background()
+
+	if resp, body := h.get(t, "/?bin=547927", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("miss: status %d, want 404, body %s", resp.StatusCode, body)
+	}
+	if err := h.store.SaveBIN(ctx, &storage.BinData{BinNumber: "547927", CardBrand: "MASTERCARD"}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, body := h.get(t, "/?bin=547927", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("stored: status %d, body %s", resp.StatusCode, body)
+	}
+	if calls := h.provider.Calls(); calls != 0 {
+		t.Fatalf("provider called %d times in cache-only mode", calls)
+	}
+}
+
+func TestPopularBINsArePinned(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		h.get(t, "/?bin=547927", nil)
+	}
+	h.get(t, "/?bin=400000", nil)
+
+	if err := h.cache.FlushAccessCounts(ctx, h.store); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.cache.RefreshPopular(ctx, h.store); err != nil {
+		t.Fatal(err)
+	}
+	ttl, err := h.rdb.TTL(ctx, "bin-lookup-gateway:bin:547927").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl != -1 {
+		t.Fatalf("popular BIN has TTL %v, want none", ttl)
+	}
+
+	header := http.Header{"X-Admin-Key": {adminKey}}
+	resp, body := h.get(t, "/admin/popular?days=1&limit=1", header)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, body %s", resp.StatusCode, body)
+	}
+	var popular []storage.AccessCount
+	if err := json.Unmarshal(body, &popular); err != nil {
+		t.Fatal(err)
+	}
+	if len(popular) != 1 || popular[0].BinNumber != "547927" || popular[0].Count != 3 {
+		t.Fatalf("unexpected popular BINs %+v", popular)
+	}
+}
+
+func TestAccessFlushRetryKeepsBatchDay(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+	ctx := context.Background()
+	yesterday := time.Now().UTC().AddDate(0, 0, -1)
+
+	// A flush interrupted yesterday, replayed twice as if it kept failing
+	// after applying its counts.
+	for i := 0; i < 2; i++ {
+		if err := h.rdb.HSet(ctx, "bin-lookup-gateway:access-counts:flushing", "547927", 2).Err(); err != nil {
+			t.Fatal(err)
+		}
+		err := h.rdb.HSet(ctx, "bin-lookup-gateway:access-counts:batch", "id", "batch-1", "at", yesterday.UnixMilli()).Err()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := h.cache.FlushAccessCounts(ctx, h.store); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(deps.mongo.URI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(ctx)
+	var counter struct {
+		Day   time.Time `bson:"day"`
+		Count int64     `bson:"count"`
+	}
+	err = client.Database("bin-lookup-gateway").Collection("access-counts-daily").
+		FindOne(ctx, bson.D{{Key: "bin-number", Value: "547927"}}).Decode(&counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	y, m, d := yesterday.Date()
+	if !counter.Day.Equal(time.Date(y, m, d, 0, 0, 0, 0, time.UTC)) || counter.Count != 2 {
+		t.Fatalf("counter %+v, want 2 on %v", counter, yesterday.Format(time.DateOnly))
+	}
+}
+
+func TestChanges(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+	since := time.Now().UTC().Add(-time.Minute).Format(time.RFC3339)
+
+	h.get(t, "/?bin=547927", nil)
+	// Even with no settle window, records saved in the current millisecond
+	// are held back.
+	time.Sleep(5 * time.Millisecond)
+
+	page := h.changes(t, "since="+since)
+	if len(page.Records) != 1 || page.Records[0].BinNumber != "547927" || !page.HasMore {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+	if page.Records[0].UpdatedAt.IsZero() {
+		t.Fatalf("change without updated_at: %+v", page.Records[0])
+	}
+
+	page = h.changes(t, "cursor="+page.NextCursor)
+	if len(page.Records) != 0 || page.HasMore || page.NextCursor == "" {
+		t.Fatalf("unexpected last page %+v", page)
+	}
+
+	// Polling with the last cursor only returns records saved since.
+	if err := h.store.SaveBIN(context.Background(), &storage.BinData{BinNumber: "400000"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	page = h.changes(t, "cursor="+page.NextCursor)
+	if len(page.Records) != 1 || page.Records[0].BinNumber != "400000" {
+		t.Fatalf("unexpected polled page %+v", page)
+	}
+}
+
+func TestChangesIncludesLegacyRecords(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+	ctx := context.Background()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(deps.mongo.URI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(ctx)
+	// A record as saved before modification times were tracked.
+	_, err = client.Database("bin-lookup-gateway").Collection("bins").InsertOne(ctx, bson.D{{Key: "bin-number", Value: "400000"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.store.EnsureIndexes(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	page := h.changes(t, "since=2000-01-01T00:00:00Z")
+	if len(page.Records) != 1 || page.Records[0].BinNumber != "400000" || page.Records[0].UpdatedAt.IsZero() {
+		t.Fatalf("legacy record not backfilled: %+v", page)
+	}
+}
+
+func TestChangesSettleWindow(t *testing.T) {
+	h := newHarness(t, harnessOptions{changesSettleWindow: time.Hour})
+	since := time.Now().UTC().Add(-time.Minute).Format(time.RFC3339)
+
+	h.get(t, "/?bin=547927", nil)
+
+	page := h.changes(t, "since="+since)
+	if len(page.Records) != 0 || page.HasMore {
+		t.Fatalf("unsettled record returned: %+v", page)
+	}
+	cursor, err := storage.ParseChangesCursor(page.NextCursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cursor.UpdatedAt.Before(time.Now().Add(-time.Minute)) {
+		t.Fatalf("cursor moved past unsettled records: %v", cursor.UpdatedAt)
+	}
+}
+
+// changes fetches one page of /v1/changes with limit 1.
+func (h *harness) changes(t *testing.T, query string) storage.ChangesPage {
+	t.Helper()
+	resp, body := h.get(t, "/v1/changes?limit=1&"+query, http.Header{"X-Admin-Key": {adminKey}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, body %s", resp.StatusCode, body)
+	}
+	var page storage.ChangesPage
+	if err := json.Unmarshal(body, &page); err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func TestAdminEndpointsRequireKey(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+
+	for _, path := range []string{"/admin/popular", "/v1/changes?since=2024-01-01T00:00:00Z"} {
+		if resp, _ := h.get(t, path, nil); resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("%s without key: status %d, want 403", path, resp.StatusCode)
+		}
+		if resp, _ := h.get(t, path, http.Header{"X-Admin-Key": {"wrong"}}); resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("%s with wrong key: status %d, want 403", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestChangeFeedPublishesNewRecords(t *testing.T) {
+	const stream = "test:changes"
+	h := newHarness(t, harnessOptions{changeStream: stream})
+	ctx := context.Background()
+
+	h.get(t, "/?bin=547927", nil)
+	h.get(t, "/?bin=547927", nil)
+
+	var entries []redis.XMessage
+	for deadline := time.Now().Add(5 * time.Second); len(entries) == 0 && time.Now().Before(deadline); {
+		time.Sleep(50 * time.Millisecond)
+		var err error
+		if entries, err = h.rdb.XRange(ctx, stream, "-", "+").Result(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Let a wrongly published second event arrive before counting.
+	time.Sleep(200 * time.Millisecond)
+	entries, err := h.rdb.XRange(ctx, stream, "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d change events, want 1 for the saved record", len(entries))
+	}
+	if op, bin := entries[0].Values["op"], entries[0].Values["bin"]; op != changefeed.OpCreate || bin != "547927" {
+		t.Fatalf("unexpected event fields %v", entries[0].Values)
+	}
+	var event changefeed.Event
+	if err := json.Unmarshal([]byte(entries[0].Values["event"].(string)), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.ID == "" || event.Record == nil || event.Record.CardBrand != "MASTERCARD" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+}
+
+func TestReadPreferenceRouting(t *testing.T) {
+	newHarness(t, harnessOptions{})
+	ctx := context.Background()
+
+	// modes records the $readPreference mode sent with each command, keyed
+	// by command and collection; "" means none was sent.
+	var mu sync.Mutex
+	modes := map[string][]string{}
+	monitor := &event.CommandMonitor{
+		Started: func(_ context.Context, e *event.CommandStartedEvent) {
+			coll, _ := e.Command.Lookup(e.CommandName).StringValueOK()
+			mode, _ := e.Command.Lookup("$readPreference", "mode").StringValueOK()
+			mu.Lock()
+			defer mu.Unlock()
+			modes[e.CommandName+" "+coll] = append(modes[e.CommandName+" "+coll], mode)
+		},
+	}
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(deps.mongo.URI).SetMonitor(monitor))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	store := storage.New(client, storage.Options{
+		LookupReadPreference: readpref.SecondaryPreferred(),
+		AdminReadPreference:  readpref.Nearest(),
+	})
+
+	if _, err := store.FindBIN(ctx, "547927"); err != mongo.ErrNoDocuments {
+		t.Fatalf("FindBIN: %v", err)
+	}
+	if err := store.SaveBIN(ctx, &storage.BinData{BinNumber: "547927"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Popular(ctx, time.Now(), 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Changes(ctx, time.Now().Add(-time.Hour), nil, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// On a direct connection the driver sends primary as primaryPreferred.
+	want := map[string][]string{
+		// The lookup read, its fallback to the primary on a miss, and
+		// Changes, which always reads the primary.
+		"find bins":                     {"secondaryPreferred", "primaryPreferred", "primaryPreferred"},
+		"insert bins":                   {""},
+		"aggregate access-counts-daily": {"nearest"},
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for cmd, w := range want {
+		if got := modes[cmd]; fmt.Sprint(got) != fmt.Sprint(w) {
+			t.Errorf("%s: read preferences %q, want %q", cmd, got, w)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/go-redis/redis_rate/v10"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+type rateLimitedResponse struct {
+	Error        string `json:"error"`
+	Limit        int    `json:"limit"`
+	Remaining    int    `json:"remaining"`
+	RetryAfterMs int64  `json:"retry_after_ms"`
+	ResetAfterMs int64  `json:"reset_after_ms"`
+}
+
+// writeRateLimited answers a rejected request with the limiter state, so
+// clients can wait exactly until their next request would be allowed.
+// Retry-After only has second precision and is rounded up.
+func writeRateLimited(w http.ResponseWriter, res *redis_rate.Result) {
+	retryAfter := int64(math.Ceil(res.RetryAfter.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	jsonData, err := json.Marshal(rateLimitedResponse{
+		Error:        "Rate limit exceeded",
+		Limit:        res.Limit.Rate,
+		Remaining:    res.Remaining,
+		RetryAfterMs: res.RetryAfter.Milliseconds(),
+		ResetAfterMs: res.ResetAfter.Milliseconds(),
+	})
+	if err != nil {
+		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write(jsonData)
+}
@@ -0,0 +1,94 @@
+// Package server exposes the lookup pipeline and admin endpoints over HTTP.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	sentryhttp "github.com/getsentry/sentry-go/http"
+	"github.com/lari4/bin-lookup-gateway/lookup"
+	"github.com/lari4/bin-lookup-gateway/ratelimit"
+	"github.com/lari4/bin-lookup-gateway/storage"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Options configures the handler returned by New.
+type Options struct {
+	// AdminAPIKey must be sent in X-Admin-Key to reach the admin endpoints,
+	// which are disabled while it is empty.
+	AdminAPIKey string
+	// TrustForwardedFor takes the caller address from the X-Forwarded-For
+	// entry appended by our reverse proxy instead of the connection.
+	TrustForwardedFor bool
+}
+
+// Server holds the handler state; use New to get the http.Handler.
+type Server struct {
+	service *lookup.Service
+	store   *storage.Store
+	opts    Options
+}
+
+// New returns the gateway HTTP handler.
+func New(service *lookup.Service, opts Options) http.Handler {
+	s := &Server{service: service, store: service.Store, opts: opts}
+	sentryHandler := sentryhttp.New(sentryhttp.Options{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", sentryHandler.HandleFunc(s.lookupHandler))
+	mux.HandleFunc("/admin/popular", sentryHandler.HandleFunc(s.adminOnly(s.popularHandler)))
+	mux.HandleFunc("/v1/changes", sentryHandler.HandleFunc(s.adminOnly(s.changesHandler)))
+	return mux
+}
+
+func (s *Server) lookupHandler(w http.ResponseWriter, r *http.Request) {
+	bin := strings.TrimSpace(r.URL.Query().Get("bin"))
+	caller := ratelimit.Caller{APIKey: r.Header.Get("X-Api-Key"), IP: s.clientIP(r)}
+	binData, err := s.service.Lookup(r.Context(), bin, caller)
+	var limited *lookup.RateLimitedError
+	switch {
+	case err == nil:
+		writeJSON(w, binData, "Failed to encode BIN data as JSON")
+	case err == lookup.ErrInvalidBIN:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid BIN number"))
+	case err == lookup.ErrNotFound:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("No data found for this BIN/IIN number"))
+	case errors.As(err, &limited):
+		writeRateLimited(w, limited.Result)
+	default:
+		log.Printf("lookup failed: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+	}
+}
+
+// clientIP returns the caller address. With TrustForwardedFor set, the last
+// X-Forwarded-For entry is used: it is the one appended by our reverse
+// proxy, while earlier entries are supplied by the client.
+func (s *Server) clientIP(r *http.Request) net.IP {
+	if s.opts.TrustForwardedFor {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			parts := strings.Split(xff, ",")
+			return net.ParseIP(strings.TrimSpace(parts[len(parts)-1]))
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}, encodeError string) {
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, encodeError, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
+}
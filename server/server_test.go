@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		trust   bool
+		xff     string
+		want    string
+		wantNil bool
+	}{
+		{name: "remote address", want: "192.0.2.1"},
+		{name: "untrusted header ignored", xff: "203.0.113.7", want: "192.0.2.1"},
+		{name: "trusted single entry", trust: true, xff: "203.0.113.7", want: "203.0.113.7"},
+		// Earlier entries come from the client and can be forged.
+		{name: "trusted last entry", trust: true, xff: "10.0.0.5, 198.51.100.2 ,203.0.113.7", want: "203.0.113.7"},
+		{name: "trusted without header", trust: true, want: "192.0.2.1"},
+		{name: "trusted garbage", trust: true, xff: "unknown", wantNil: true},
+	}
+	for _, tt := range tests {
+		s := &Server{opts: Options{TrustForwardedFor: tt.trust}}
+		r := httptest.NewRequest("GET", "/?bin=547927", nil)
+		r.RemoteAddr = "192.0.2.1:52000"
+		if tt.xff != "" {
+			r.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		got := s.clientIP(r)
+		if tt.wantNil {
+			if got != nil {
+				t.Errorf("%s: got %v, want nil", tt.name, got)
+			}
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("%s: got %v, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"time"
+)
+
+// AccessCount is the number of lookups recorded for a BIN prefix over the
+// requested window.
+type AccessCount struct {
+	BinNumber  string    `bson:"bin-number" json:"bin"`
+	Count      int64     `bson:"count" json:"count"`
+	LastSeenAt time.Time `bson:"last-seen-at" json:"last_seen_at"`
+}
+
+// accessCounts holds one counter per BIN prefix and UTC day, so popularity
+// can be ranked over a recent window instead of all time.
+func (s *Store) accessCounts(rp *readpref.ReadPref) *mongo.Collection {
+	return s.collection("access-counts-daily", rp)
+}
+
+// day returns the UTC midnight starting t's day.
+func day(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// appliedBatchesKept is how many recent flush batch IDs each counter
+// remembers. Flushes are serialised, so only the latest one can be retried.
+const appliedBatchesKept = 10
+
+// IncAccessCounts adds counts, keyed by BIN prefix, to the counters of
+// batchAt's day. Each counter records batchID, so retrying a batch that was
+// partially applied only adds the counts that are still missing, provided
+// the retry passes the same batchAt.
+func (s *Store) IncAccessCounts(ctx context.Context, batchID string, batchAt time.Time, counts map[string]int64) error {
+	if len(counts) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	batchDay := day(batchAt)
+	models := make([]mongo.WriteModel, 0, len(counts))
+	for prefix, n := range counts {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{
+				{Key: "bin-number", Value: prefix},
+				{Key: "day", Value: batchDay},
+				{Key: "applied-batches", Value: bson.D{{Key: "$ne", Value: batchID}}},
+			}).
+			SetUpdate(bson.D{
+				{Key: "$inc", Value: bson.D{{Key: "count", Value: n}}},
+				{Key: "$set", Value: bson.D{{Key: "last-seen-at", Value: now}}},
+				{Key: "$push", Value: bson.D{{Key: "applied-batches", Value: bson.D{
+					{Key: "$each", Value: bson.A{batchID}},
+					{Key: "$slice", Value: -appliedBatchesKept},
+				}}}},
+			}).
+			SetUpsert(true))
+	}
+	_, err := s.accessCounts(readpref.Primary()).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if onlyDuplicateKeys(err) {
+		// The counter already has batchID, so the filter did not match and
+		// the upsert collided with the unique bin-number and day index.
+		return nil
+	}
+	return err
+}
+
+// onlyDuplicateKeys reports whether every write in a failed bulk write was
+// rejected with a duplicate key error.
+func onlyDuplicateKeys(err error) bool {
+	var bwe mongo.BulkWriteException
+	if !errors.As(err, &bwe) || bwe.WriteConcernError != nil || len(bwe.WriteErrors) == 0 {
+		return false
+	}
+	for _, we := range bwe.WriteErrors {
+		if we.Code != 11000 {
+			return false
+		}
+	}
+	return true
+}
+
+// Popular returns the BIN prefixes looked up most since the start of
+// since's UTC day, most popular first.
+func (s *Store) Popular(ctx context.Context, since time.Time, limit int64) ([]AccessCount, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "day", Value: bson.D{{Key: "$gte", Value: day(since)}}}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$bin-number"},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: "$count"}}},
+			{Key: "last-seen-at", Value: bson.D{{Key: "$max", Value: "$last-seen-at"}}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$set", Value: bson.D{{Key: "bin-number", Value: "$_id"}}}},
+	}
+	cursor, err := s.accessCounts(s.adminReadPref).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	result := []AccessCount{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
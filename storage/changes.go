@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrMalformedCursor is returned by ParseChangesCursor for invalid cursors.
+var ErrMalformedCursor = errors.New("malformed cursor")
+
+// ChangesPage is one page of records returned by Changes.
+type ChangesPage struct {
+	Records []ChangedRecord `json:"records"`
+	// NextCursor is passed back as ?cursor= to fetch the next page, or to
+	// poll for later changes once HasMore is false.
+	NextCursor string `json:"next_cursor"`
+	HasMore    bool   `json:"has_more"`
+}
+
+// ChangedRecord is a record together with its modification times, which
+// only the changes feed exposes.
+type ChangedRecord struct {
+	BinData
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ChangesCursor is the position of the last record returned. Records are
+// ordered by updated-at and then _id so that records sharing a timestamp are
+// neither skipped nor repeated across pages.
+type ChangesCursor struct {
+	UpdatedAt time.Time
+	ID        primitive.ObjectID
+}
+
+// maxObjectID sorts after every _id sharing an updated-at value.
+var maxObjectID = primitive.ObjectID{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// String encodes the cursor for the next_cursor field.
+func (c ChangesCursor) String() string {
+	return strconv.FormatInt(c.UpdatedAt.UnixMilli(), 10) + "_" + c.ID.Hex()
+}
+
+func (c ChangesCursor) after(o ChangesCursor) bool {
+	if !c.UpdatedAt.Equal(o.UpdatedAt) {
+		return c.UpdatedAt.After(o.UpdatedAt)
+	}
+	return bytes.Compare(c.ID[:], o.ID[:]) > 0
+}
+
+// ParseChangesCursor decodes a cursor produced by String.
+func ParseChangesCursor(s string) (ChangesCursor, error) {
+	ms, id, ok := strings.Cut(s, "_")
+	if !ok {
+		return ChangesCursor{}, ErrMalformedCursor
+	}
+	n, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return ChangesCursor{}, ErrMalformedCursor
+	}
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ChangesCursor{}, ErrMalformedCursor
+	}
+	return ChangesCursor{UpdatedAt: time.UnixMilli(n).UTC(), ID: oid}, nil
+}
+
+// Changes returns up to limit records modified after since, or after the
+// cursor position when one is given.
+//
+// updated-at is stamped by the gateway before the insert commits, so a
+// record can become visible after a consumer already read past its
+// timestamp. Changes therefore only returns records older than the settle
+// window, and once there are no more pages the cursor advances to that
+// horizon rather than past records still in flight. Records are skipped only
+// if an insert takes longer than the window to commit or gateway clocks are
+// further apart than it. Reads go to the primary so that replication lag
+// does not add to that delay.
+func (s *Store) Changes(ctx context.Context, since time.Time, cursor *ChangesCursor, limit int64) (*ChangesPage, error) {
+	// Stored times have millisecond precision, which makes "after since"
+	// the same as after the last possible record at since's millisecond.
+	from := ChangesCursor{UpdatedAt: since.UTC().Truncate(time.Millisecond), ID: maxObjectID}
+	if cursor != nil {
+		from = *cursor
+	}
+	// The horizon is exclusive: a record may still be saved within its
+	// millisecond, so only earlier ones are settled.
+	horizon := time.Now().UTC().Add(-s.changesSettleWindow).Truncate(time.Millisecond)
+
+	filter := bson.D{
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "updated-at", Value: bson.D{{Key: "$gt", Value: from.UpdatedAt}}}},
+			bson.D{
+				{Key: "updated-at", Value: from.UpdatedAt},
+				{Key: "_id", Value: bson.D{{Key: "$gt", Value: from.ID}}},
+			},
+		}},
+		{Key: "updated-at", Value: bson.D{{Key: "$lt", Value: horizon}}},
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "updated-at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(limit)
+
+	result, err := s.bins(readpref.Primary()).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var records []BinData
+	if err := result.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	page := &ChangesPage{Records: make([]ChangedRecord, 0, len(records))}
+	for _, r := range records {
+		page.Records = append(page.Records, ChangedRecord{BinData: r, CreatedAt: r.CreatedAt, UpdatedAt: r.UpdatedAt})
+	}
+	next := from
+	if int64(len(records)) == limit {
+		last := records[len(records)-1]
+		next = ChangesCursor{UpdatedAt: last.UpdatedAt, ID: last.ID}
+		page.HasMore = true
+	} else if settled := (ChangesCursor{UpdatedAt: horizon.Add(-time.Millisecond), ID: maxObjectID}); settled.after(from) {
+		// Everything before the horizon has been returned.
+		next = settled
+	}
+	page.NextCursor = next.String()
+	return page, nil
+}
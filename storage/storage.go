@@ -0,0 +1,234 @@
+// Package storage keeps BIN records and lookup statistics in MongoDB.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/lari4/bin-lookup-gateway/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"time"
+)
+
+const databaseName = "bin-lookup-gateway"
+
+// BinData is a BIN record as returned by the upstream provider.
+type BinData struct {
+	Country       string `bson:"country"`
+	CountryCode   string `bson:"country-code"`
+	CardBrand     string `bson:"card-brand"`
+	IsCommercial  bool   `bson:"is-commercial"`
+	BinNumber     string `bson:"bin-number"`
+	Issuer        string `bson:"issuer"`
+	IssuerWebsite string `bson:"issuer-website"`
+	Valid         bool   `bson:"valid"`
+	CardType      string `bson:"card-type"`
+	IsPrepaid     bool   `bson:"is-prepaid"`
+	CardCategory  string `bson:"card-category"`
+	IssuerPhone   string `bson:"issuer-phone"`
+	CurrencyCode  string `bson:"currency-code"`
+	CountryCode3  string `bson:"country-code3"`
+
+	// The bookkeeping fields are not part of the lookup response; see
+	// ChangedRecord for the timestamps.
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	CreatedAt time.Time          `bson:"created-at,omitempty" json:"-"`
+	UpdatedAt time.Time          `bson:"updated-at,omitempty" json:"-"`
+}
+
+// BinPrefix returns the six digit prefix that records are stored under.
+func BinPrefix(bin string) string {
+	if len(bin) > 6 {
+		return bin[:6]
+	}
+	return bin
+}
+
+// Store reads and writes the gateway's collections. Create it with Connect,
+// or with New to reuse an existing client.
+type Store struct {
+	client *mongo.Client
+	// lookupReadPref is used for BIN lookups, which tolerate replication lag.
+	// Writes always go to the primary.
+	lookupReadPref *readpref.ReadPref
+	// adminReadPref is used for reads made by the admin endpoints.
+	adminReadPref *readpref.ReadPref
+	// changesSettleWindow is how old records must be before Changes returns
+	// them.
+	changesSettleWindow time.Duration
+}
+
+// Options configures a Store created with New. Nil read preferences mean
+// primary.
+type Options struct {
+	LookupReadPreference *readpref.ReadPref
+	AdminReadPreference  *readpref.ReadPref
+	// ChangesSettleWindow delays records in Changes, see there.
+	ChangesSettleWindow time.Duration
+}
+
+// New returns a Store on an already connected client. Disconnect closes the
+// client, so callers sharing it should close it themselves instead.
+func New(client *mongo.Client, opts Options) *Store {
+	s := &Store{
+		client:              client,
+		lookupReadPref:      opts.LookupReadPreference,
+		adminReadPref:       opts.AdminReadPreference,
+		changesSettleWindow: opts.ChangesSettleWindow,
+	}
+	if s.lookupReadPref == nil {
+		s.lookupReadPref = readpref.Primary()
+	}
+	if s.adminReadPref == nil {
+		s.adminReadPref = readpref.Primary()
+	}
+	return s
+}
+
+// Connect opens and pings a MongoDB connection configured by cfg.
+func Connect(ctx context.Context, cfg config.Mongo) (*Store, error) {
+	lookupReadPref, err := parseReadPref(cfg.LookupReadPreference)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MONGO_LOOKUP_READ_PREFERENCE: %w", err)
+	}
+	adminReadPref, err := parseReadPref(cfg.AdminReadPreference)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MONGO_ADMIN_READ_PREFERENCE: %w", err)
+	}
+
+	mongoURI := cfg.URI
+	if mongoURI == "" {
+		port := cfg.Port
+		if port == 0 {
+			port = 27017
+		}
+		mongoURI = fmt.Sprintf("mongodb://%s:%s@%s:%d", cfg.Username, cfg.Password, cfg.Host, port)
+	}
+	// Options present in the URI take precedence over the pool settings.
+	clientOptions := options.Client().
+		SetMinPoolSize(cfg.MinPoolSize).
+		SetMaxPoolSize(cfg.MaxPoolSize).
+		SetMaxConnIdleTime(cfg.MaxConnIdleTime).
+		ApplyURI(mongoURI)
+
+	connectCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(connectCtx, clientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+	}
+
+	// It's a good practice to ping the MongoDB server to ensure connection is successful
+	pingCtx, cancelPing := context.WithTimeout(ctx, 2*time.Second)
+	defer cancelPing()
+	if err := client.Ping(pingCtx, nil); err != nil {
+		return nil, fmt.Errorf("failed to ping MongoDB: %w", err)
+	}
+
+	return New(client, Options{
+		LookupReadPreference: lookupReadPref,
+		AdminReadPreference:  adminReadPref,
+		ChangesSettleWindow:  cfg.ChangesSettleWindow,
+	}), nil
+}
+
+// Disconnect closes the underlying client.
+func (s *Store) Disconnect(ctx context.Context) error {
+	return s.client.Disconnect(ctx)
+}
+
+// parseReadPref converts a read preference mode name such as
+// "secondaryPreferred" into a read preference. An empty value means primary.
+func parseReadPref(mode string) (*readpref.ReadPref, error) {
+	if mode == "" {
+		return readpref.Primary(), nil
+	}
+	m, err := readpref.ModeFromString(mode)
+	if err != nil {
+		return nil, err
+	}
+	return readpref.New(m)
+}
+
+func (s *Store) collection(name string, rp *readpref.ReadPref) *mongo.Collection {
+	return s.client.Database(databaseName).Collection(name, options.Collection().SetReadPreference(rp))
+}
+
+func (s *Store) bins(rp *readpref.ReadPref) *mongo.Collection {
+	return s.collection("bins", rp)
+}
+
+// EnsureIndexes creates the indexes the queries rely on and backfills
+// fields added since records were first stored. It is safe to call on every
+// start.
+func (s *Store) EnsureIndexes(ctx context.Context) error {
+	_, err := s.accessCounts(readpref.Primary()).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "bin-number", Value: 1}, {Key: "day", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "day", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create access count index: %w", err)
+	}
+	_, err = s.bins(readpref.Primary()).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "updated-at", Value: 1}, {Key: "_id", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create BIN changes index: %w", err)
+	}
+	// Records saved before modification times were tracked get them from
+	// their _id, which embeds the insert time, so Changes returns them too.
+	_, err = s.bins(readpref.Primary()).UpdateMany(ctx,
+		bson.D{{Key: "updated-at", Value: bson.D{{Key: "$exists", Value: false}}}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.D{
+			{Key: "created-at", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$created-at", bson.D{{Key: "$toDate", Value: "$_id"}}}}}},
+			{Key: "updated-at", Value: bson.D{{Key: "$toDate", Value: "$_id"}}},
+		}}}})
+	if err != nil {
+		return fmt.Errorf("failed to backfill BIN modification times: %w", err)
+	}
+	return nil
+}
+
+// FindBIN returns the stored record for the BIN's six digit prefix, or
+// mongo.ErrNoDocuments.
+func (s *Store) FindBIN(ctx context.Context, bin string) (*BinData, error) {
+	result, err := findBIN(ctx, s.bins(s.lookupReadPref), bin)
+	if err == mongo.ErrNoDocuments && s.lookupReadPref.Mode() != readpref.PrimaryMode {
+		// The record may have been saved moments ago and not replicated yet;
+		// checking the primary is much cheaper than a paid upstream request.
+		return findBIN(ctx, s.bins(readpref.Primary()), bin)
+	}
+	return result, err
+}
+
+func findBIN(ctx context.Context, collection *mongo.Collection, bin string) (*BinData, error) {
+	regexPattern := "^" + BinPrefix(bin)
+
+	filter := bson.D{{Key: "bin-number", Value: bson.D{{Key: "$regex", Value: regexPattern}}}}
+
+	opts := options.FindOne().SetSort(bson.D{{Key: "bin-number", Value: -1}})
+
+	var result BinData
+	err := collection.FindOne(ctx, filter, opts).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SaveBIN inserts a new record, stamping its creation and update times.
+func (s *Store) SaveBIN(ctx context.Context, binData *BinData) error {
+	// Mongo stores dates with millisecond precision; truncating keeps the
+	// value in memory equal to the stored one.
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	binData.CreatedAt = now
+	binData.UpdatedAt = now
+	_, err := s.bins(readpref.Primary()).InsertOne(ctx, binData)
+	return err
+}